@@ -5,6 +5,7 @@
 package gocql
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -12,6 +13,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -22,6 +24,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var (
+	// errPoolClosed is returned by PickContext/Get when the pool is closed
+	// while a caller is waiting for a connection.
+	errPoolClosed = errors.New("connectionpool: pool closed")
+	// errNoStreamsAvailable is returned by PickContext/Get when every conn
+	// in the pool is saturated.
+	errNoStreamsAvailable = errors.New("connectionpool: no streams available")
+)
+
 // interface to implement to receive the host information
 type SetHosts interface {
 	SetHosts(hosts []*HostInfo)
@@ -82,7 +93,110 @@ type policyConnPool struct {
 
 	endpoints []string
 
-	numHosts prometheus.GaugeFunc
+	minConns        int
+	maxConns        int
+	maxConnIdleTime time.Duration
+
+	// localDC/localRack identify this client's locality, as reported by
+	// ClusterConfig; hosts are tiered against them to decide pool size.
+	// Local-rack hosts use minConns/maxConns like before; numConnsRemoteDC
+	// is the separate baseline for hosts in a remote DC.
+	localDC          string
+	localRack        string
+	numConnsRemoteDC int
+
+	numHosts   prometheus.GaugeFunc
+	hostStates *prometheus.GaugeVec
+	hostTiers  *prometheus.GaugeVec
+
+	healthPolicy HostHealthPolicy
+	healthMu     sync.Mutex
+	hostHealth   map[string]*hostHealth
+
+	quit        chan struct{}
+	reconnectWG sync.WaitGroup
+}
+
+// hostHealthState describes whether a host is currently eligible to serve
+// queries or has been taken out of rotation pending a successful probe.
+type hostHealthState int32
+
+const (
+	hostStateUp hostHealthState = iota
+	hostStateQuarantined
+)
+
+func (s hostHealthState) String() string {
+	switch s {
+	case hostStateQuarantined:
+		return "quarantined"
+	default:
+		return "up"
+	}
+}
+
+// HostHealthPolicy decides when a host that is experiencing connection
+// failures should be quarantined (pulled out of the pool but retried in the
+// background) rather than treated as healthy.
+type HostHealthPolicy interface {
+	// ShouldQuarantine reports whether a host with the given number of
+	// consecutive connection failures (failed connects and dropped conns)
+	// and EWMA failure rate (0..1) should be quarantined.
+	ShouldQuarantine(consecutiveFailures int, failureRate float64) bool
+}
+
+// defaultHostHealthPolicy is used when ClusterConfig.HostHealthPolicy is nil.
+// It quarantines a host after 3 consecutive connection failures, or once its
+// EWMA failure rate climbs above 50%.
+type defaultHostHealthPolicy struct{}
+
+func (defaultHostHealthPolicy) ShouldQuarantine(consecutiveFailures int, failureRate float64) bool {
+	return consecutiveFailures >= 3 || failureRate > 0.5
+}
+
+// hostHealthEWMAWeight is the weight given to the newest sample when
+// updating a host's failure rate EWMA. Kept low enough that two
+// consecutive failures alone don't push the rate past the 0.5 threshold in
+// defaultHostHealthPolicy, so its documented 3-failure grace period is the
+// branch that actually applies before the rate-based one can.
+const hostHealthEWMAWeight = 0.25
+
+// hostHealth tracks per-host connection failure statistics so that
+// policyConnPool can decide when to quarantine a host and when a
+// quarantined host has recovered.
+type hostHealth struct {
+	mu                  sync.Mutex
+	host                *HostInfo
+	state               hostHealthState
+	consecutiveFailures int
+	failureRate         float64 // EWMA of connection failures, 0..1
+}
+
+func (h *hostHealth) recordFailure() (consecutiveFailures int, failureRate float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutiveFailures++
+	h.failureRate = hostHealthEWMAWeight + (1-hostHealthEWMAWeight)*h.failureRate
+	return h.consecutiveFailures, h.failureRate
+}
+
+func (h *hostHealth) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.consecutiveFailures = 0
+	h.failureRate = (1 - hostHealthEWMAWeight) * h.failureRate
+}
+
+func (h *hostHealth) setState(state hostHealthState) {
+	h.mu.Lock()
+	h.state = state
+	h.mu.Unlock()
+}
+
+func (h *hostHealth) getState() hostHealthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
 }
 
 func connConfig(cfg *ClusterConfig) (*ConnConfig, error) {
@@ -115,15 +229,41 @@ func connConfig(cfg *ClusterConfig) (*ConnConfig, error) {
 }
 
 func newPolicyConnPool(logger log.Logger, registerer prometheus.Registerer, session *Session) *policyConnPool {
+	healthPolicy := session.cfg.HostHealthPolicy
+	if healthPolicy == nil {
+		healthPolicy = defaultHostHealthPolicy{}
+	}
+
+	minConns := session.cfg.MinConns
+	if minConns <= 0 {
+		// preserve the historical behaviour of eagerly filling to NumConns
+		// when the warm-pool knobs aren't configured
+		minConns = session.cfg.NumConns
+	}
+	maxConns := session.cfg.MaxConns
+	if maxConns < minConns {
+		// growth disabled unless the user opts in with a larger MaxConns
+		maxConns = minConns
+	}
+
 	// create the pool
 	pool := &policyConnPool{
-		logger:        logger,
-		registerer:    registerer,
-		session:       session,
-		port:          session.cfg.Port,
-		numConns:      session.cfg.NumConns,
-		keyspace:      session.cfg.Keyspace,
-		hostConnPools: map[string]*hostConnPool{},
+		logger:           logger,
+		registerer:       registerer,
+		session:          session,
+		port:             session.cfg.Port,
+		numConns:         session.cfg.NumConns,
+		keyspace:         session.cfg.Keyspace,
+		hostConnPools:    map[string]*hostConnPool{},
+		healthPolicy:     healthPolicy,
+		hostHealth:       map[string]*hostHealth{},
+		quit:             make(chan struct{}),
+		minConns:         minConns,
+		maxConns:         maxConns,
+		maxConnIdleTime:  session.cfg.MaxConnIdleTime,
+		localDC:          session.cfg.LocalDC,
+		localRack:        session.cfg.LocalRack,
+		numConnsRemoteDC: session.cfg.NumConnsRemoteDC,
 	}
 
 	pool.endpoints = make([]string, len(session.cfg.Hosts))
@@ -138,9 +278,38 @@ func newPolicyConnPool(logger log.Logger, registerer prometheus.Registerer, sess
 		return float64(len(pool.hostConnPools))
 	})
 
+	pool.hostStates = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gocql_host_state",
+		Help: "State of a host as seen by the connection pool, 1 for the active state and 0 otherwise.",
+	}, []string{"host", "state"})
+
+	pool.hostTiers = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gocql_connection_pool_host_tier",
+		Help: "Locality tier assigned to a host (local_rack, remote_dc, other), 1 for the assigned tier and 0 otherwise.",
+	}, []string{"host", "tier"})
+
+	pool.reconnectWG.Add(1)
+	go pool.reconnectQuarantinedHosts()
+
 	return pool
 }
 
+// maxSizeFor returns the growth ceiling for a pool in the given tier whose
+// tiered minimum is numConns. Only the local-rack tier is allowed to grow
+// towards the configured maxConns under load; remote/other tiers are
+// pinned to their own (smaller) baseline so a saturated cross-DC pool can't
+// grow to the same size as a local-rack one, which would defeat the point
+// of tiering connection counts by locality in the first place.
+func (p *policyConnPool) maxSizeFor(tier string, numConns int) int {
+	if tier != hostTierLocalRack {
+		return numConns
+	}
+	if p.maxConns > numConns {
+		return p.maxConns
+	}
+	return numConns
+}
+
 func (p *policyConnPool) SetHosts(hosts []*HostInfo) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -164,19 +333,37 @@ func (p *policyConnPool) SetHosts(hosts []*HostInfo) {
 			continue
 		}
 
+		if p.healthFor(host).getState() == hostStateQuarantined {
+			// this host is serving the backoff our reconnector enforces;
+			// only probeQuarantinedHosts, or hostUp once gossip reports
+			// the host back, re-admits it
+			continue
+		}
+
+		tier, numConns := p.connsForHost(host)
+		p.setHostTier(host, tier)
+		if numConns <= 0 {
+			// this host's tier doesn't warrant a pool (e.g. a different
+			// rack within the local DC)
+			continue
+		}
+
 		createCount++
-		go func(host *HostInfo) {
+		go func(host *HostInfo, tier string, numConns int) {
 			// create a connection pool for the host
 			pools <- newHostConnPool(
 				p.logger,
 				p.registerer,
 				p.session,
+				p,
 				host,
 				p.port,
-				p.numConns,
+				numConns,
+				p.maxSizeFor(tier, numConns),
+				p.maxConnIdleTime,
 				p.keyspace,
 			)
-		}(host)
+		}(host, tier, numConns)
 	}
 
 	// add created pools
@@ -217,8 +404,13 @@ func (p *policyConnPool) getPool(host *HostInfo) (pool *hostConnPool, ok bool) {
 }
 
 func (p *policyConnPool) Close() {
+	close(p.quit)
+	p.reconnectWG.Wait()
+
 	if p.registerer != nil {
 		p.registerer.Unregister(p.numHosts)
+		p.registerer.Unregister(p.hostStates)
+		p.registerer.Unregister(p.hostTiers)
 	}
 
 	p.mu.Lock()
@@ -232,18 +424,220 @@ func (p *policyConnPool) Close() {
 	}
 }
 
+// healthFor returns the hostHealth entry for ip, creating one in the "up"
+// state if it doesn't already exist.
+func (p *policyConnPool) healthFor(host *HostInfo) *hostHealth {
+	ip := host.ConnectAddress().String()
+
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+
+	h, ok := p.hostHealth[ip]
+	if !ok {
+		h = &hostHealth{host: host}
+		p.hostHealth[ip] = h
+	}
+	return h
+}
+
+// setHostState records the current health state of host in the
+// gocql_host_state gauge, clearing the gauge for the state it left.
+func (p *policyConnPool) setHostState(host *HostInfo, state hostHealthState) {
+	ip := host.ConnectAddress().String()
+	p.hostStates.WithLabelValues(ip, hostStateUp.String()).Set(0)
+	p.hostStates.WithLabelValues(ip, hostStateQuarantined.String()).Set(0)
+	p.hostStates.WithLabelValues(ip, state.String()).Set(1)
+}
+
+// Locality tiers used to size a host's connection pool relative to this
+// client's LocalDC/LocalRack.
+const (
+	hostTierLocalRack = "local_rack"
+	hostTierRemoteDC  = "remote_dc"
+	hostTierOther     = "other"
+)
+
+// connsForHost returns the locality tier host falls into relative to
+// p.localDC/p.localRack, and the number of conns a pool for that host
+// should be sized to: minConns (the usual NumConns/MinConns baseline) for
+// hosts in the local rack, numConnsRemoteDC for hosts in a different DC,
+// and zero for anything else (e.g. a different rack within the local DC),
+// unless LocalDC/LocalRack aren't configured, in which case every host is
+// treated as local.
+func (p *policyConnPool) connsForHost(host *HostInfo) (tier string, numConns int) {
+	if p.localDC == "" || (host.DataCenter() == p.localDC && (p.localRack == "" || host.Rack() == p.localRack)) {
+		return hostTierLocalRack, p.minConns
+	}
+	if host.DataCenter() != p.localDC {
+		return hostTierRemoteDC, p.numConnsRemoteDC
+	}
+	return hostTierOther, 0
+}
+
+// setHostTier records host's locality tier in the
+// gocql_connection_pool_host_tier gauge, clearing the gauge for the tiers
+// it doesn't belong to.
+func (p *policyConnPool) setHostTier(host *HostInfo, tier string) {
+	ip := host.ConnectAddress().String()
+	for _, t := range []string{hostTierLocalRack, hostTierRemoteDC, hostTierOther} {
+		p.hostTiers.WithLabelValues(ip, t).Set(0)
+	}
+	p.hostTiers.WithLabelValues(ip, tier).Set(1)
+}
+
+// recordHostFailure tracks a connection failure for host and quarantines it,
+// pulling it out of hostConnPools in favour of background re-probing, once
+// the configured HostHealthPolicy says it is unhealthy.
+func (p *policyConnPool) recordHostFailure(host *HostInfo, err error) {
+	health := p.healthFor(host)
+	consecutiveFailures, failureRate := health.recordFailure()
+
+	if health.getState() == hostStateQuarantined || !p.healthPolicy.ShouldQuarantine(consecutiveFailures, failureRate) {
+		return
+	}
+
+	health.setState(hostStateQuarantined)
+	p.setHostState(host, hostStateQuarantined)
+	level.Info(p.logger).Log("msg", "quarantining host", "host", host.ConnectAddress(), "consecutive_failures", consecutiveFailures)
+
+	p.removeHost(host.ConnectAddress())
+}
+
+// recordHostSuccess clears any accumulated failures for host, marking it
+// healthy again.
+func (p *policyConnPool) recordHostSuccess(host *HostInfo) {
+	health := p.healthFor(host)
+	health.recordSuccess()
+	if health.getState() != hostStateUp {
+		health.setState(hostStateUp)
+		p.setHostState(host, hostStateUp)
+	}
+}
+
+// reconnectQuarantinedHosts periodically attempts to dial quarantined hosts,
+// using a jittered exponential backoff derived from the session's
+// ReconnectionPolicy, and re-admits any that accept a connection.
+func (p *policyConnPool) reconnectQuarantinedHosts() {
+	defer p.reconnectWG.Done()
+
+	reconnectionPolicy := p.session.cfg.ReconnectionPolicy
+	attempt := 0
+
+	for {
+		interval := reconnectionPolicy.GetInterval(attempt)
+		// jitter the interval so that many quarantined hosts don't all get
+		// probed in lockstep
+		jitter := time.Duration(rand.Int63n(int64(interval)/2 + 1))
+
+		select {
+		case <-p.quit:
+			return
+		case <-time.After(interval + jitter):
+		}
+
+		if p.probeQuarantinedHosts() {
+			attempt = 0
+		} else if attempt < reconnectionPolicy.GetMaxRetries() {
+			attempt++
+		}
+	}
+}
+
+// probeQuarantinedHosts dials every currently quarantined host once, and
+// re-admits any host that accepts a connection. It reports whether any host
+// was re-admitted.
+func (p *policyConnPool) probeQuarantinedHosts() bool {
+	p.healthMu.Lock()
+	quarantined := make([]*hostHealth, 0)
+	for _, h := range p.hostHealth {
+		if h.getState() == hostStateQuarantined {
+			quarantined = append(quarantined, h)
+		}
+	}
+	p.healthMu.Unlock()
+
+	recovered := false
+	for _, h := range quarantined {
+		select {
+		case <-p.quit:
+			return recovered
+		default:
+		}
+
+		tier, numConns := p.connsForHost(h.host)
+		p.setHostTier(h.host, tier)
+		if numConns <= 0 {
+			continue
+		}
+
+		probe := newHostConnPool(p.logger, p.registerer, p.session, p, h.host, p.port, numConns, p.maxSizeFor(tier, numConns), p.maxConnIdleTime, p.keyspace)
+		probe.fill()
+		if probe.Size() == 0 {
+			probe.deregisterMetrics()
+			probe.Close()
+			continue
+		}
+
+		h.recordSuccess()
+		h.setState(hostStateUp)
+		p.setHostState(h.host, hostStateUp)
+		level.Info(p.logger).Log("msg", "quarantined host responded to probe, re-admitting", "host", h.host.ConnectAddress())
+
+		addr := h.host.ConnectAddress().String()
+		p.mu.Lock()
+		existing, exists := p.hostConnPools[addr]
+		p.hostConnPools[addr] = probe
+		p.mu.Unlock()
+
+		if exists {
+			// something else (addHost/SetHosts/hostUp) admitted a pool for
+			// this host while the probe dial was in flight; close the one
+			// we're superseding so it doesn't leak conns, its reaper
+			// goroutine, or collide with probe's just-registered metrics.
+			existing.deregisterMetrics()
+			existing.Close()
+		}
+
+		recovered = true
+	}
+
+	return recovered
+}
+
 func (p *policyConnPool) addHost(host *HostInfo) {
 	ip := host.ConnectAddress().String()
+
+	tier, numConns := p.connsForHost(host)
+	p.setHostTier(host, tier)
+
 	p.mu.Lock()
 	pool, ok := p.hostConnPools[ip]
 	if !ok {
+		if numConns <= 0 {
+			// this host's tier doesn't warrant a pool (e.g. a different
+			// rack within the local DC)
+			p.mu.Unlock()
+			return
+		}
+
+		if p.healthFor(host).getState() == hostStateQuarantined {
+			// this host is serving the backoff our reconnector enforces;
+			// only probeQuarantinedHosts, or hostUp once gossip reports
+			// the host back, re-admits it
+			p.mu.Unlock()
+			return
+		}
+
 		pool = newHostConnPool(
 			p.logger,
 			p.registerer,
 			p.session,
+			p,
 			host,
 			host.Port(), // TODO: if port == 0 use pool.port?
-			p.numConns,
+			numConns,
+			p.maxSizeFor(tier, numConns),
+			p.maxConnIdleTime,
 			p.keyspace,
 		)
 
@@ -271,66 +665,337 @@ func (p *policyConnPool) removeHost(ip net.IP) {
 }
 
 func (p *policyConnPool) hostUp(host *HostInfo) {
-	// TODO(zariel): have a set of up hosts and down hosts, we can internally
-	// detect down hosts, then try to reconnect to them.
+	// the host is back according to gossip; drop any quarantine bookkeeping
+	// and let addHost establish a fresh pool for it.
+	p.healthFor(host).setState(hostStateUp)
+	p.setHostState(host, hostStateUp)
 	p.addHost(host)
 }
 
 func (p *policyConnPool) hostDown(ip net.IP) {
-	// TODO(zariel): mark host as down so we can try to connect to it later, for
-	// now just treat it has removed.
+	// the host was reported down by gossip, as opposed to a quarantine
+	// inferred from connect failures; remove it outright and let hostUp
+	// re-add it once the cluster sees it again.
 	p.removeHost(ip)
 }
 
+// ConnSelectionPolicy chooses which *Conn a hostConnPool hands out for a
+// given query, and is kept in sync with the pool's live conns via Init/Add/
+// Remove. Set ClusterConfig.ConnSelectionPolicy to a factory that returns
+// one per host pool; a nil factory defaults to StreamAwareConnPolicy.
+type ConnSelectionPolicy interface {
+	// Init seeds the policy with the pool's conns at construction time
+	// (empty for a freshly created pool).
+	Init(conns []*Conn)
+	// Pick returns a conn to use for query, or nil if none are available.
+	Pick(query *Query) *Conn
+	// Add is called whenever connect() adds a new conn to the pool.
+	Add(conn *Conn)
+	// Remove is called whenever a conn is dropped from the pool, whether
+	// by HandleError or idle reaping.
+	Remove(conn *Conn)
+}
+
+// ConnSelectionPolicyFactory builds a new ConnSelectionPolicy for a host
+// connection pool. Assign one to ClusterConfig.ConnSelectionPolicy.
+type ConnSelectionPolicyFactory func() ConnSelectionPolicy
+
+// connCheckoutTracker is an optional interface a ConnSelectionPolicy can
+// implement to be told when hostConnPool.Get hands a conn out and when the
+// matching ConnCheckout.Put brings it back, e.g. to maintain an in-flight
+// count. Not part of ConnSelectionPolicy itself since plain Pick callers
+// have no matching "done" signal to pair with Get.
+type connCheckoutTracker interface {
+	Get(conn *Conn)
+	Put(conn *Conn)
+}
+
+// RoundRobinConnPolicy cycles through conns in order, ignoring load.
+type RoundRobinConnPolicy struct {
+	mu    sync.Mutex
+	conns []*Conn
+	pos   uint32
+}
+
+// NewRoundRobinConnPolicy creates a ConnSelectionPolicy that cycles through
+// a pool's conns in order.
+func NewRoundRobinConnPolicy() ConnSelectionPolicy {
+	return &RoundRobinConnPolicy{}
+}
+
+func (r *RoundRobinConnPolicy) Init(conns []*Conn) {
+	r.mu.Lock()
+	r.conns = append([]*Conn(nil), conns...)
+	r.mu.Unlock()
+}
+
+func (r *RoundRobinConnPolicy) Add(conn *Conn) {
+	r.mu.Lock()
+	r.conns = append(r.conns, conn)
+	r.mu.Unlock()
+}
+
+func (r *RoundRobinConnPolicy) Remove(conn *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.conns {
+		if c == conn {
+			r.conns[i], r.conns = r.conns[len(r.conns)-1], r.conns[:len(r.conns)-1]
+			return
+		}
+	}
+}
+
+func (r *RoundRobinConnPolicy) Pick(_ *Query) *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size := len(r.conns)
+	if size == 0 {
+		return nil
+	}
+	pos := int(atomic.AddUint32(&r.pos, 1) - 1)
+	return r.conns[pos%size]
+}
+
+// LeastOutstandingConnPolicy picks the conn with the fewest in-flight
+// checkouts. The count is not touched by Pick itself - it only reflects
+// checkouts made through hostConnPool.Get, via the Get/Put methods below,
+// which hostConnPool calls through an optional interface since they aren't
+// part of ConnSelectionPolicy.
+type LeastOutstandingConnPolicy struct {
+	mu       sync.Mutex
+	conns    []*Conn
+	inFlight map[*Conn]*int32
+}
+
+// NewLeastOutstandingConnPolicy creates a ConnSelectionPolicy that picks the
+// conn with the fewest outstanding Get/Put checkouts.
+func NewLeastOutstandingConnPolicy() ConnSelectionPolicy {
+	return &LeastOutstandingConnPolicy{inFlight: make(map[*Conn]*int32)}
+}
+
+func (l *LeastOutstandingConnPolicy) Init(conns []*Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns = append([]*Conn(nil), conns...)
+	l.inFlight = make(map[*Conn]*int32, len(conns))
+	for _, c := range conns {
+		l.inFlight[c] = new(int32)
+	}
+}
+
+func (l *LeastOutstandingConnPolicy) Add(conn *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conns = append(l.conns, conn)
+	l.inFlight[conn] = new(int32)
+}
+
+func (l *LeastOutstandingConnPolicy) Remove(conn *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, c := range l.conns {
+		if c == conn {
+			l.conns[i], l.conns = l.conns[len(l.conns)-1], l.conns[:len(l.conns)-1]
+			break
+		}
+	}
+	delete(l.inFlight, conn)
+}
+
+func (l *LeastOutstandingConnPolicy) Pick(_ *Query) *Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var best *Conn
+	var bestCount int32
+	for _, c := range l.conns {
+		count := atomic.LoadInt32(l.inFlight[c])
+		if best == nil || count < bestCount {
+			best, bestCount = c, count
+		}
+	}
+	return best
+}
+
+// Get marks conn as checked-out, bumping its in-flight count. Called by
+// hostConnPool.Get.
+func (l *LeastOutstandingConnPolicy) Get(conn *Conn) {
+	l.mu.Lock()
+	counter, ok := l.inFlight[conn]
+	l.mu.Unlock()
+	if ok {
+		atomic.AddInt32(counter, 1)
+	}
+}
+
+// Put releases the in-flight slot Get reserved for conn. Called by
+// ConnCheckout.Put.
+func (l *LeastOutstandingConnPolicy) Put(conn *Conn) {
+	l.mu.Lock()
+	counter, ok := l.inFlight[conn]
+	l.mu.Unlock()
+	if ok {
+		atomic.AddInt32(counter, -1)
+	}
+}
+
+// StreamAwareConnPolicy picks the conn reporting the most AvailableStreams,
+// scanning round-robin from the last position so equally-free conns are
+// spread across evenly. This is the default policy.
+type StreamAwareConnPolicy struct {
+	mu    sync.Mutex
+	conns []*Conn
+	pos   uint32
+}
+
+// NewStreamAwareConnPolicy creates the default ConnSelectionPolicy, which
+// picks the conn with the most available streams.
+func NewStreamAwareConnPolicy() ConnSelectionPolicy {
+	return &StreamAwareConnPolicy{}
+}
+
+func (s *StreamAwareConnPolicy) Init(conns []*Conn) {
+	s.mu.Lock()
+	s.conns = append([]*Conn(nil), conns...)
+	s.mu.Unlock()
+}
+
+func (s *StreamAwareConnPolicy) Add(conn *Conn) {
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *StreamAwareConnPolicy) Remove(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.conns {
+		if c == conn {
+			s.conns[i], s.conns = s.conns[len(s.conns)-1], s.conns[:len(s.conns)-1]
+			return
+		}
+	}
+}
+
+func (s *StreamAwareConnPolicy) Pick(_ *Query) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := len(s.conns)
+	if size == 0 {
+		return nil
+	}
+
+	pos := int(atomic.AddUint32(&s.pos, 1) - 1)
+	var conn *Conn
+	bestStreams := 0
+	for i := 0; i < size; i++ {
+		candidate := s.conns[(pos+i)%size]
+		if streams := candidate.AvailableStreams(); streams > bestStreams || conn == nil {
+			conn, bestStreams = candidate, streams
+		}
+	}
+	if bestStreams <= 0 {
+		return nil
+	}
+	return conn
+}
+
 // hostConnPool is a connection pool for a single host.
-// Connection selection is based on a provided ConnSelectionPolicy
+// Connection selection is based on a provided ConnSelectionPolicy.
 type hostConnPool struct {
 	logger     log.Logger
 	registerer prometheus.Registerer
 
-	session  *Session
-	host     *HostInfo
-	port     int
-	addr     string
-	size     int
-	keyspace string
+	session *Session
+	parent  *policyConnPool
+	host    *HostInfo
+	port    int
+	addr    string
+	// minSize is the pool's warm size: fill() eagerly connects up to this
+	// many conns and the reaper never closes idle conns below it.
+	minSize int
+	// maxSize is the ceiling growTarget may grow to under sustained
+	// saturation; equal to minSize when dynamic growth is disabled.
+	maxSize         int
+	maxConnIdleTime time.Duration
+	keyspace        string
 	// protection for conns, closed, filling
 	mu      sync.RWMutex
 	conns   []*Conn
 	closed  bool
 	filling bool
-
-	pos uint32
+	// cond is signalled whenever connect() adds a conn or the pool is
+	// closed, so PickContext/Get can block on the first conn becoming
+	// available instead of racing fill() with retries.
+	cond *sync.Cond
+	// growTarget is the pool's current fill target; starts at minSize and
+	// is bumped towards maxSize by Pick when every conn is saturated.
+	// Accessed atomically so Pick can read/bump it while holding only mu's
+	// read lock.
+	growTarget int32
+	// lastUsed records when each conn was last handed out by Pick, so the
+	// reaper can identify conns that have been idle beyond maxConnIdleTime.
+	// Guarded by its own mutex since Pick only holds mu's read lock.
+	lastUsedMu sync.Mutex
+	lastUsed   map[*Conn]time.Time
+
+	// connPolicy decides which conn Pick/PickContext hand out; kept in
+	// sync with conns via connPolicy.Add/Remove.
+	connPolicy ConnSelectionPolicy
+
+	quit     chan struct{}
+	reaperWG sync.WaitGroup
 
 	connections        prometheus.GaugeFunc
 	connectionAttempts prometheus.Counter
 	connectionFailures prometheus.Counter
 	connectionDrops    prometheus.Counter
+	idleReaps          prometheus.Counter
+	dynamicGrows       prometheus.Counter
 }
 
 func (h *hostConnPool) String() string {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return fmt.Sprintf("[filling=%v closed=%v conns=%v size=%v host=%v]",
-		h.filling, h.closed, len(h.conns), h.size, h.host)
+	return fmt.Sprintf("[filling=%v closed=%v conns=%v minSize=%v maxSize=%v host=%v]",
+		h.filling, h.closed, len(h.conns), h.minSize, h.maxSize, h.host)
 }
 
-func newHostConnPool(logger log.Logger, registerer prometheus.Registerer, session *Session, host *HostInfo, port, size int,
-	keyspace string) *hostConnPool {
+func newHostConnPool(logger log.Logger, registerer prometheus.Registerer, session *Session, parent *policyConnPool, host *HostInfo, port, minSize, maxSize int,
+	maxConnIdleTime time.Duration, keyspace string) *hostConnPool {
+
+	if maxSize < minSize {
+		maxSize = minSize
+	}
 
 	pool := &hostConnPool{
-		logger:     logger,
-		registerer: prometheus.WrapRegistererWith(prometheus.Labels{"host": host.ConnectAddress().String()}, registerer),
-		session:    session,
-		host:       host,
-		port:       port,
-		addr:       (&net.TCPAddr{IP: host.ConnectAddress(), Port: host.Port()}).String(),
-		size:       size,
-		keyspace:   keyspace,
-		conns:      make([]*Conn, 0, size),
-		filling:    false,
-		closed:     false,
+		logger:          logger,
+		registerer:      prometheus.WrapRegistererWith(prometheus.Labels{"host": host.ConnectAddress().String()}, registerer),
+		session:         session,
+		parent:          parent,
+		host:            host,
+		port:            port,
+		addr:            (&net.TCPAddr{IP: host.ConnectAddress(), Port: host.Port()}).String(),
+		minSize:         minSize,
+		maxSize:         maxSize,
+		maxConnIdleTime: maxConnIdleTime,
+		growTarget:      int32(minSize),
+		keyspace:        keyspace,
+		conns:           make([]*Conn, 0, minSize),
+		lastUsed:        make(map[*Conn]time.Time, minSize),
+		filling:         false,
+		closed:          false,
+		quit:            make(chan struct{}),
 	}
+	pool.cond = sync.NewCond(&pool.mu)
+
+	newConnPolicy := session.cfg.ConnSelectionPolicy
+	if newConnPolicy == nil {
+		newConnPolicy = NewStreamAwareConnPolicy
+	}
+	pool.connPolicy = newConnPolicy()
+	pool.connPolicy.Init(nil)
 
 	pool.connections = promauto.With(pool.registerer).NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "gocql_connection_pool_connections",
@@ -350,6 +1015,19 @@ func newHostConnPool(logger log.Logger, registerer prometheus.Registerer, sessio
 		Name: "gocql_connection_pool_connection_drops_total",
 		Help: "Number of TCP connection drops for given host",
 	})
+	pool.idleReaps = promauto.With(pool.registerer).NewCounter(prometheus.CounterOpts{
+		Name: "gocql_connection_pool_idle_reaps_total",
+		Help: "Number of idle connections closed by the pool reaper for given host",
+	})
+	pool.dynamicGrows = promauto.With(pool.registerer).NewCounter(prometheus.CounterOpts{
+		Name: "gocql_connection_pool_dynamic_grows_total",
+		Help: "Number of times the pool grew beyond its minimum size for given host",
+	})
+
+	if maxConnIdleTime > 0 {
+		pool.reaperWG.Add(1)
+		go pool.reapIdleConns()
+	}
 
 	// the pool is not filled or connected
 	return pool
@@ -360,44 +1038,205 @@ func (pool *hostConnPool) deregisterMetrics() {
 	pool.registerer.Unregister(pool.connectionAttempts)
 	pool.registerer.Unregister(pool.connectionFailures)
 	pool.registerer.Unregister(pool.connectionDrops)
+	pool.registerer.Unregister(pool.idleReaps)
+	pool.registerer.Unregister(pool.dynamicGrows)
+}
+
+// reapIdleConns periodically closes conns that have been idle beyond
+// maxConnIdleTime, never dropping the pool below minSize.
+func (pool *hostConnPool) reapIdleConns() {
+	defer pool.reaperWG.Done()
+
+	ticker := time.NewTicker(pool.maxConnIdleTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.quit:
+			return
+		case <-ticker.C:
+			pool.reapIdle()
+		}
+	}
+}
+
+func (pool *hostConnPool) reapIdle() {
+	now := time.Now()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.closed {
+		return
+	}
+
+	pool.lastUsedMu.Lock()
+	defer pool.lastUsedMu.Unlock()
+
+	// Rank conns most-recently-used first so the minSize floor protects
+	// whichever conns are actually freshest, not whichever happen to sit
+	// in the first minSize slice slots.
+	byRecency := append([]*Conn(nil), pool.conns...)
+	sort.Slice(byRecency, func(i, j int) bool {
+		return pool.lastUsed[byRecency[i]].After(pool.lastUsed[byRecency[j]])
+	})
+
+	live := pool.conns[:0:0]
+	for _, conn := range byRecency {
+		if len(live) < pool.minSize || now.Sub(pool.lastUsed[conn]) < pool.maxConnIdleTime {
+			live = append(live, conn)
+			continue
+		}
+
+		delete(pool.lastUsed, conn)
+		pool.connPolicy.Remove(conn)
+		pool.idleReaps.Inc()
+		go conn.Close()
+	}
+
+	if len(live) <= pool.minSize {
+		// shrink the grow target back down now that the extra conns it
+		// justified have gone idle
+		atomic.StoreInt32(&pool.growTarget, int32(pool.minSize))
+	}
+
+	pool.conns = live
+}
+
+// hostConnPoolGrowThreshold is the "available streams" low-water mark below
+// which Pick considers the pool saturated and worth growing towards maxSize.
+const hostConnPoolGrowThreshold = 10
+
+// afterPick records conn as just-used and, if the pool looks saturated,
+// nudges growTarget towards maxSize and kicks off a fill.
+func (pool *hostConnPool) afterPick(conn *Conn, streamsAvailable, target int) {
+	if conn == nil {
+		return
+	}
+
+	pool.lastUsedMu.Lock()
+	pool.lastUsed[conn] = time.Now()
+	pool.lastUsedMu.Unlock()
+
+	if streamsAvailable < hostConnPoolGrowThreshold && target < pool.maxSize {
+		if atomic.CompareAndSwapInt32(&pool.growTarget, int32(target), int32(target+1)) {
+			pool.dynamicGrows.Inc()
+			go pool.fill()
+		}
+	}
 }
 
 // Pick a connection from this connection pool for the given query.
 func (pool *hostConnPool) Pick() *Conn {
 	pool.mu.RLock()
-	defer pool.mu.RUnlock()
 
 	if pool.closed {
+		pool.mu.RUnlock()
 		return nil
 	}
 
 	size := len(pool.conns)
-	if size < pool.size {
+	target := int(atomic.LoadInt32(&pool.growTarget))
+	if size < target {
 		// try to fill the pool
 		go pool.fill()
 
 		if size == 0 {
+			pool.mu.RUnlock()
 			return nil
 		}
 	}
 
-	pos := int(atomic.AddUint32(&pool.pos, 1) - 1)
+	conn := pool.connPolicy.Pick(nil)
+	streamsAvailable := 0
+	if conn != nil {
+		streamsAvailable = conn.AvailableStreams()
+	}
+	pool.mu.RUnlock()
 
-	var (
-		leastBusyConn    *Conn
-		streamsAvailable int
-	)
+	pool.afterPick(conn, streamsAvailable, target)
+	return conn
+}
 
-	// find the conn which has the most available streams, this is racy
-	for i := 0; i < size; i++ {
-		conn := pool.conns[(pos+i)%size]
-		if streams := conn.AvailableStreams(); streams > streamsAvailable {
-			leastBusyConn = conn
-			streamsAvailable = streams
+// PickContext is like Pick, but if the pool has no conns yet (e.g. the
+// first query right after SetHosts) it blocks until either a conn becomes
+// available, the pool is closed, or ctx is done - rather than forcing the
+// caller into its own retry loop.
+func (pool *hostConnPool) PickContext(ctx context.Context) (*Conn, error) {
+	// wake any Wait() below if ctx is done before a conn shows up
+	watchDone := make(chan struct{})
+	defer close(watchDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pool.mu.Lock()
+			pool.cond.Broadcast()
+			pool.mu.Unlock()
+		case <-watchDone:
+		}
+	}()
+
+	pool.mu.Lock()
+	for len(pool.conns) == 0 {
+		if pool.closed {
+			pool.mu.Unlock()
+			return nil, errPoolClosed
 		}
+		if err := ctx.Err(); err != nil {
+			pool.mu.Unlock()
+			return nil, err
+		}
+
+		go pool.fill()
+		pool.cond.Wait()
+	}
+
+	target := int(atomic.LoadInt32(&pool.growTarget))
+	conn := pool.connPolicy.Pick(nil)
+	streamsAvailable := 0
+	if conn != nil {
+		streamsAvailable = conn.AvailableStreams()
+	}
+	pool.mu.Unlock()
+
+	pool.afterPick(conn, streamsAvailable, target)
+	if conn == nil {
+		return nil, errNoStreamsAvailable
+	}
+	return conn, nil
+}
+
+// ConnCheckout is a conn borrowed from a hostConnPool via Get. Callers must
+// call Put when they're done so policies that track in-flight checkouts
+// (e.g. LeastOutstandingConnPolicy) stay accurate.
+type ConnCheckout struct {
+	*Conn
+	pool *hostConnPool
+}
+
+// Put returns the checked-out conn to its pool, notifying the pool's
+// ConnSelectionPolicy if it tracks in-flight checkouts.
+func (c *ConnCheckout) Put() {
+	if tracker, ok := c.pool.connPolicy.(connCheckoutTracker); ok {
+		tracker.Put(c.Conn)
+	}
+}
+
+// Get is like PickContext, but returns a ConnCheckout that notifies the
+// pool's ConnSelectionPolicy the conn is checked out until Put is called,
+// for policies that weigh outstanding checkouts rather than only
+// AvailableStreams().
+func (pool *hostConnPool) Get(ctx context.Context) (*ConnCheckout, error) {
+	conn, err := pool.PickContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if tracker, ok := pool.connPolicy.(connCheckoutTracker); ok {
+		tracker.Get(conn)
 	}
 
-	return leastBusyConn
+	return &ConnCheckout{Conn: conn, pool: pool}, nil
 }
 
 //Size returns the number of connections currently active in the pool
@@ -431,8 +1270,21 @@ func (pool *hostConnPool) Close() {
 	conns := pool.conns
 	pool.conns = nil
 
+	// wake any PickContext/Get callers blocked in cond.Wait() so they can
+	// observe pool.closed and return errPoolClosed
+	pool.cond.Broadcast()
+
 	pool.mu.Unlock()
 
+	if pool.maxConnIdleTime > 0 {
+		close(pool.quit)
+		pool.reaperWG.Wait()
+	}
+
+	pool.lastUsedMu.Lock()
+	pool.lastUsed = nil
+	pool.lastUsedMu.Unlock()
+
 	// close the connections
 	for _, conn := range conns {
 		conn.Close()
@@ -450,7 +1302,7 @@ func (pool *hostConnPool) fill() {
 
 	// determine the filling work to be done
 	startCount := len(pool.conns)
-	fillCount := pool.size - startCount
+	fillCount := int(atomic.LoadInt32(&pool.growTarget)) - startCount
 
 	// avoid filling a full (or overfull) pool
 	if fillCount <= 0 {
@@ -464,7 +1316,7 @@ func (pool *hostConnPool) fill() {
 
 	// double check everything since the lock was released
 	startCount = len(pool.conns)
-	fillCount = pool.size - startCount
+	fillCount = int(atomic.LoadInt32(&pool.growTarget)) - startCount
 	if pool.closed || pool.filling || fillCount <= 0 {
 		// looks like another goroutine already beat this
 		// goroutine to the filling
@@ -573,11 +1425,12 @@ func (pool *hostConnPool) connect() (err error) {
 	defer func() {
 		if err != nil {
 			pool.connectionFailures.Inc()
+			if pool.parent != nil {
+				pool.parent.recordHostFailure(pool.host, err)
+			}
 		}
 	}()
 
-	// TODO: provide a more robust connection retry mechanism, we should also
-	// be able to detect hosts that come up by trying to connect to downed ones.
 	// try to connect
 	var conn *Conn
 	reconnectionPolicy := pool.session.cfg.ReconnectionPolicy
@@ -623,6 +1476,19 @@ func (pool *hostConnPool) connect() (err error) {
 
 	pool.conns = append(pool.conns, conn)
 
+	pool.lastUsedMu.Lock()
+	pool.lastUsed[conn] = time.Now()
+	pool.lastUsedMu.Unlock()
+
+	pool.connPolicy.Add(conn)
+
+	// wake any PickContext/Get callers blocked waiting for the first conn
+	pool.cond.Broadcast()
+
+	if pool.parent != nil {
+		pool.parent.recordHostSuccess(pool.host)
+	}
+
 	return nil
 }
 
@@ -637,8 +1503,12 @@ func (pool *hostConnPool) HandleError(conn *Conn, err error, closed bool) {
 
 	pool.connectionDrops.Inc()
 
-	// TODO: track the number of errors per host and detect when a host is dead,
-	// then also have something which can detect when a host comes back.
+	if pool.parent != nil {
+		// track the error against the host's health; this may quarantine
+		// the host and pull it out of pool.parent.hostConnPools
+		pool.parent.recordHostFailure(pool.host, err)
+	}
+
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
@@ -653,6 +1523,12 @@ func (pool *hostConnPool) HandleError(conn *Conn, err error, closed bool) {
 			// remove the connection, not preserving order
 			pool.conns[i], pool.conns = pool.conns[len(pool.conns)-1], pool.conns[:len(pool.conns)-1]
 
+			pool.lastUsedMu.Lock()
+			delete(pool.lastUsed, conn)
+			pool.lastUsedMu.Unlock()
+
+			pool.connPolicy.Remove(conn)
+
 			// lost a connection, so fill the pool
 			go pool.fill()
 			break
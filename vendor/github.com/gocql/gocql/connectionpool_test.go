@@ -0,0 +1,170 @@
+// Copyright (c) 2012 The gocql Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gocql
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinConnPolicy_CyclesInOrder(t *testing.T) {
+	p := NewRoundRobinConnPolicy()
+	c1, c2, c3 := new(Conn), new(Conn), new(Conn)
+	p.Init([]*Conn{c1, c2, c3})
+
+	want := []*Conn{c1, c2, c3}
+	for i := 0; i < 6; i++ {
+		if got := p.Pick(nil); got != want[i%3] {
+			t.Fatalf("pick %d = %p, want %p", i, got, want[i%3])
+		}
+	}
+
+	p.Remove(c2)
+	for i := 0; i < 4; i++ {
+		if got := p.Pick(nil); got == c2 {
+			t.Fatalf("Pick returned conn %p after it was removed", c2)
+		}
+	}
+}
+
+func TestLeastOutstandingConnPolicy_PicksFewestOutstanding(t *testing.T) {
+	p := NewLeastOutstandingConnPolicy().(*LeastOutstandingConnPolicy)
+	c1, c2 := new(Conn), new(Conn)
+	p.Init([]*Conn{c1, c2})
+
+	p.Get(c1)
+	p.Get(c1)
+	p.Get(c2)
+
+	if got := p.Pick(nil); got != c2 {
+		t.Fatalf("Pick() = %p, want least-outstanding conn %p", got, c2)
+	}
+}
+
+func TestLeastOutstandingConnPolicy_PutReleasesSlot(t *testing.T) {
+	p := NewLeastOutstandingConnPolicy().(*LeastOutstandingConnPolicy)
+	c1, c2 := new(Conn), new(Conn)
+	p.Init([]*Conn{c1, c2})
+
+	p.Get(c1)
+	p.Put(c1)
+
+	// c1's checkout was released, so its count is back to 0 and it's
+	// eligible to be picked again alongside c2.
+	picked := map[*Conn]bool{}
+	for i := 0; i < 2; i++ {
+		picked[p.Pick(nil)] = true
+	}
+	if !picked[c1] {
+		t.Fatalf("Put did not release c1's in-flight slot: never picked c1 (picked=%v)", picked)
+	}
+}
+
+func TestLeastOutstandingConnPolicy_ConcurrentGetPut(t *testing.T) {
+	p := NewLeastOutstandingConnPolicy().(*LeastOutstandingConnPolicy)
+	conns := []*Conn{new(Conn), new(Conn), new(Conn)}
+	p.Init(conns)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				conn := p.Pick(nil)
+				p.Get(conn)
+				p.Put(conn)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, c := range conns {
+		if n := atomic.LoadInt32(p.inFlight[c]); n != 0 {
+			t.Fatalf("conn %p in-flight count = %d, want 0 once all Get/Put pairs finished", c, n)
+		}
+	}
+}
+
+func TestStreamAwareConnPolicy_AddRemove(t *testing.T) {
+	p := NewStreamAwareConnPolicy().(*StreamAwareConnPolicy)
+	c1, c2 := new(Conn), new(Conn)
+	p.Init([]*Conn{c1})
+	p.Add(c2)
+
+	if len(p.conns) != 2 {
+		t.Fatalf("len(conns) = %d, want 2", len(p.conns))
+	}
+
+	p.Remove(c1)
+	if len(p.conns) != 1 || p.conns[0] != c2 {
+		t.Fatalf("conns after Remove = %v, want [%p]", p.conns, c2)
+	}
+}
+
+// newTestHostConnPool builds a hostConnPool by hand rather than through
+// newHostConnPool, so tests of PickContext's blocking/cancellation
+// semantics don't need a live Session/Conn to dial. growTarget is pinned at
+// 0 so pool.fill() is always a no-op and never touches pool.session.
+func newTestHostConnPool() *hostConnPool {
+	pool := &hostConnPool{
+		connPolicy: NewRoundRobinConnPolicy(),
+		maxSize:    0,
+		growTarget: 0,
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+	return pool
+}
+
+func TestHostConnPoolPickContext_CancelsOnContextDone(t *testing.T) {
+	pool := newTestHostConnPool()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.PickContext(ctx)
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("PickContext returned %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("PickContext did not unblock after ctx was canceled")
+	}
+}
+
+func TestHostConnPoolPickContext_UnblocksOnClose(t *testing.T) {
+	pool := newTestHostConnPool()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.PickContext(context.Background())
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	pool.mu.Lock()
+	pool.closed = true
+	pool.cond.Broadcast()
+	pool.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if err != errPoolClosed {
+			t.Fatalf("PickContext returned %v, want errPoolClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("PickContext did not unblock after the pool was closed")
+	}
+}